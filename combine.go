@@ -0,0 +1,133 @@
+package retag
+
+import (
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// Combine returns a TagMaker whose MakeTag concatenates the tags produced by
+// each of the given makers into a single reflect.StructTag. Tags are merged
+// key by key: if two makers produce a value for the same tag key, the one
+// that comes later in makers wins.
+//
+// The returned maker compares equal to any other value produced by Combine
+// over an equal slice of makers, so getType's cache still treats repeated
+// Combine calls over the same makers as the same key. This requires every
+// maker in makers to itself be comparable, as required by TagMaker.
+func Combine(makers ...TagMaker) TagMaker {
+	arrType := reflect.ArrayOf(len(makers), reflect.TypeOf((*TagMaker)(nil)).Elem())
+	arrVal := reflect.New(arrType).Elem()
+	for i, maker := range makers {
+		arrVal.Index(i).Set(reflect.ValueOf(maker))
+	}
+	return combinedMaker{makers: arrVal.Interface()}
+}
+
+type combinedMaker struct {
+	// makers holds a [N]TagMaker array built via reflect.ArrayOf so that
+	// combinedMaker stays comparable regardless of how many makers it combines.
+	makers interface{}
+}
+
+func (c combinedMaker) MakeTag(structType reflect.Type, fieldIndex int) reflect.StructTag {
+	arrVal := reflect.ValueOf(c.makers)
+	tags := make([]string, arrVal.Len())
+	for i := range tags {
+		maker := arrVal.Index(i).Interface().(TagMaker)
+		tags[i] = string(maker.MakeTag(structType, fieldIndex))
+	}
+	return mergeTags(tags...)
+}
+
+// Preserve wraps maker so that the tag it produces is merged with the field's
+// existing tag on the source struct, instead of replacing it outright. Where
+// both define the same tag key, the value produced by maker wins. This lets a
+// maker add e.g. a db tag without discarding a hand-written json tag.
+func Preserve(maker TagMaker) TagMaker {
+	return preservingMaker{maker: maker}
+}
+
+type preservingMaker struct {
+	maker TagMaker
+}
+
+func (p preservingMaker) MakeTag(structType reflect.Type, fieldIndex int) reflect.StructTag {
+	original := structType.Field(fieldIndex).Tag
+	generated := p.maker.MakeTag(structType, fieldIndex)
+	return mergeTags(string(original), string(generated))
+}
+
+// mergeTags parses each tag string in order and merges them into one tag,
+// keeping the first-seen order of keys but letting later tags in the list
+// overwrite earlier values for the same key.
+func mergeTags(tags ...string) reflect.StructTag {
+	order := make([]string, 0, len(tags))
+	values := make(map[string]string, len(tags))
+	for _, tag := range tags {
+		for _, pair := range parseTag(tag) {
+			if _, ok := values[pair.key]; !ok {
+				order = append(order, pair.key)
+			}
+			values[pair.key] = pair.value
+		}
+	}
+	var b strings.Builder
+	for i, key := range order {
+		if i > 0 {
+			b.WriteByte(' ')
+		}
+		b.WriteString(key)
+		b.WriteByte(':')
+		b.WriteString(strconv.Quote(values[key]))
+	}
+	return reflect.StructTag(b.String())
+}
+
+type tagPair struct {
+	key   string
+	value string
+}
+
+// parseTag splits a struct tag into its key/value pairs. The algorithm
+// mirrors reflect.StructTag.Lookup, which only exposes one key at a time.
+func parseTag(tag string) []tagPair {
+	var pairs []tagPair
+	for tag != "" {
+		i := 0
+		for i < len(tag) && tag[i] == ' ' {
+			i++
+		}
+		tag = tag[i:]
+		if tag == "" {
+			break
+		}
+		i = 0
+		for i < len(tag) && tag[i] > ' ' && tag[i] != ':' && tag[i] != '"' && tag[i] != 0x7f {
+			i++
+		}
+		if i == 0 || i+1 >= len(tag) || tag[i] != ':' || tag[i+1] != '"' {
+			break
+		}
+		key := tag[:i]
+		tag = tag[i+1:]
+		i = 1
+		for i < len(tag) && tag[i] != '"' {
+			if tag[i] == '\\' {
+				i++
+			}
+			i++
+		}
+		if i >= len(tag) {
+			break
+		}
+		qvalue := tag[:i+1]
+		tag = tag[i+1:]
+		value, err := strconv.Unquote(qvalue)
+		if err != nil {
+			break
+		}
+		pairs = append(pairs, tagPair{key: key, value: value})
+	}
+	return pairs
+}
@@ -0,0 +1,73 @@
+package retag_test
+
+import (
+	"reflect"
+	"testing"
+
+	retag "github.com/slowaner/go-retag"
+)
+
+type constTagMaker struct {
+	field string
+	tag   string
+}
+
+func (m constTagMaker) MakeTag(t reflect.Type, i int) reflect.StructTag {
+	if t.Field(i).Name != m.field {
+		return t.Field(i).Tag
+	}
+	return reflect.StructTag(m.tag)
+}
+
+type Person struct {
+	Name string `xml:"name"`
+}
+
+// TestCombineLaterMakerWins checks that Combine concatenates distinct tag
+// keys from each maker and that, for a key both makers produce, the later
+// maker in the list wins.
+func TestCombineLaterMakerWins(t *testing.T) {
+	maker := retag.Combine(
+		constTagMaker{field: "Name", tag: `json:"name" db:"name"`},
+		constTagMaker{field: "Name", tag: `json:"full_name"`},
+	)
+	result := retag.Convert(&Person{}, maker)
+	tag := reflect.TypeOf(result).Elem().Field(0).Tag
+
+	if got, want := tag.Get("json"), "full_name"; got != want {
+		t.Errorf("json tag = %q, want %q (later maker should win)", got, want)
+	}
+	if got, want := tag.Get("db"), "name"; got != want {
+		t.Errorf("db tag = %q, want %q (should survive from the earlier maker)", got, want)
+	}
+}
+
+// TestCombineIsComparable checks that two Combine calls over equal maker
+// lists produce a maker that Convert's cache treats as the same key, i.e.
+// Combine's result stays comparable as TagMaker requires.
+func TestCombineIsComparable(t *testing.T) {
+	m1 := retag.Combine(constTagMaker{field: "Name", tag: `json:"a"`})
+	m2 := retag.Combine(constTagMaker{field: "Name", tag: `json:"a"`})
+
+	r1 := retag.Convert(&Person{}, m1)
+	r2 := retag.Convert(&Person{}, m2)
+	if reflect.TypeOf(r1) != reflect.TypeOf(r2) {
+		t.Error("Convert generated distinct types for two Combine makers built from equal maker lists")
+	}
+}
+
+// TestPreserveMergesWithOriginalTag checks that Preserve keeps the source
+// field's existing tag for keys the wrapped maker doesn't touch, while
+// letting the wrapped maker override keys it does produce.
+func TestPreserveMergesWithOriginalTag(t *testing.T) {
+	maker := retag.Preserve(constTagMaker{field: "Name", tag: `db:"name"`})
+	result := retag.Convert(&Person{}, maker)
+	tag := reflect.TypeOf(result).Elem().Field(0).Tag
+
+	if got, want := tag.Get("xml"), "name"; got != want {
+		t.Errorf("xml tag = %q, want %q (original tag should be preserved)", got, want)
+	}
+	if got, want := tag.Get("db"), "name"; got != want {
+		t.Errorf("db tag = %q, want %q (maker's tag should be added)", got, want)
+	}
+}
@@ -0,0 +1,95 @@
+package retag_test
+
+import (
+	"reflect"
+	"testing"
+
+	retag "github.com/slowaner/go-retag"
+)
+
+type Item struct {
+	Name string `json:"name"`
+}
+
+type itemSuffixMaker struct{}
+
+func (itemSuffixMaker) MakeTag(t reflect.Type, i int) reflect.StructTag {
+	return reflect.StructTag(`json:"` + t.Field(i).Name + `_retagged"`)
+}
+
+// TestConvertSliceRoot checks that Convert accepts a pointer to a slice of
+// structures, retagging the element type and preserving every element's
+// value.
+func TestConvertSliceRoot(t *testing.T) {
+	src := &[]Item{{Name: "a"}, {Name: "b"}}
+	result := retag.Convert(src, itemSuffixMaker{})
+
+	rv := reflect.ValueOf(result).Elem()
+	if got, want := rv.Type().Elem().Field(0).Tag, reflect.StructTag(`json:"Name_retagged"`); got != want {
+		t.Errorf("element tag = %q, want %q", got, want)
+	}
+	if got, want := rv.Len(), 2; got != want {
+		t.Fatalf("len = %d, want %d", got, want)
+	}
+	if got, want := rv.Index(0).FieldByName("Name").String(), "a"; got != want {
+		t.Errorf("element 0 Name = %q, want %q", got, want)
+	}
+	if got, want := rv.Index(1).FieldByName("Name").String(), "b"; got != want {
+		t.Errorf("element 1 Name = %q, want %q", got, want)
+	}
+}
+
+// TestConvertMapRoot checks that Convert accepts a pointer to a map whose
+// values are structures, retagging the value type and preserving every
+// entry's value.
+func TestConvertMapRoot(t *testing.T) {
+	src := &map[string]Item{"x": {Name: "x-value"}}
+	result := retag.Convert(src, itemSuffixMaker{})
+
+	rv := reflect.ValueOf(result).Elem()
+	if got, want := rv.Type().Elem().Field(0).Tag, reflect.StructTag(`json:"Name_retagged"`); got != want {
+		t.Errorf("value tag = %q, want %q", got, want)
+	}
+	entry := rv.MapIndex(reflect.ValueOf("x"))
+	if !entry.IsValid() {
+		t.Fatal("missing entry for key \"x\"")
+	}
+	if got, want := entry.FieldByName("Name").String(), "x-value"; got != want {
+		t.Errorf("entry Name = %q, want %q", got, want)
+	}
+}
+
+// TestConvertArrayRoot checks that Convert accepts a pointer to an array of
+// structures, retagging the element type and preserving every element's
+// value.
+func TestConvertArrayRoot(t *testing.T) {
+	src := &[2]Item{{Name: "first"}, {Name: "second"}}
+	result := retag.Convert(src, itemSuffixMaker{})
+
+	rv := reflect.ValueOf(result).Elem()
+	if got, want := rv.Type().Elem().Field(0).Tag, reflect.StructTag(`json:"Name_retagged"`); got != want {
+		t.Errorf("element tag = %q, want %q", got, want)
+	}
+	if got, want := rv.Index(0).FieldByName("Name").String(), "first"; got != want {
+		t.Errorf("element 0 Name = %q, want %q", got, want)
+	}
+	if got, want := rv.Index(1).FieldByName("Name").String(), "second"; got != want {
+		t.Errorf("element 1 Name = %q, want %q", got, want)
+	}
+}
+
+// TestConvertPtrRoot checks that Convert accepts a pointer to a pointer to a
+// structure, retagging the pointed-to structure's type.
+func TestConvertPtrRoot(t *testing.T) {
+	inner := &Item{Name: "nested"}
+	src := &inner
+	result := retag.Convert(src, itemSuffixMaker{})
+
+	rv := reflect.ValueOf(result).Elem().Elem()
+	if got, want := rv.Type().Field(0).Tag, reflect.StructTag(`json:"Name_retagged"`); got != want {
+		t.Errorf("field tag = %q, want %q", got, want)
+	}
+	if got, want := rv.FieldByName("Name").String(), "nested"; got != want {
+		t.Errorf("Name = %q, want %q", got, want)
+	}
+}
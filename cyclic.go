@@ -0,0 +1,266 @@
+package retag
+
+import (
+	"fmt"
+	"reflect"
+	"runtime"
+	"sync/atomic"
+	"unsafe"
+)
+
+// ConvertCyclic is like Convert, but also supports self-referential type
+// graphs (e.g. type Node struct { Next *Node }), which Convert's single-pass
+// getType/makeType recursion would otherwise never terminate on.
+//
+// It builds the result in two passes. The first walks the type graph from p
+// and, for every struct it is still in the middle of building, hands out a
+// throwaway placeholder type everywhere that struct is reached again through
+// a pointer or slice edge, instead of recursing into it a second time.
+// The second pass runs the moment each struct's real analogue is finished:
+// it rewrites the Elem field of every placeholder's pointer/slice wrapper
+// type in place to refer to the finished struct.
+// No copy of the wrapper type is made, so the one patch fixes every field
+// anywhere in the graph that used it.
+//
+// Only struct, pointer, slice, map and array kinds are walked, same as
+// Convert/makeType does; see makeType's panic for the kinds that aren't
+// supported. Like Convert, ConvertCyclic panics if a struct has unexported
+// fields whose tag the maker wants to change; combining unexported fields
+// with cyclic types isn't supported; use ConvertUnsafe for the former.
+//
+// A cycle may not reach back to itself through a map typed by value (e.g.
+// map[string]Node where Node's own field graph leads back to Node); a map's
+// key/element size, hasher and bucket layout are baked into its type and
+// can't be patched after the fact the way a pointer or slice's element can
+// be, so ConvertCyclic panics rather than risk corrupting map storage. Use a
+// pointer element instead (map[string]*Node).
+//
+// Any pointer or slice type that had to be wrapped around a cyclic
+// struct's placeholder reports an empty Name() and String(), for the same
+// reason ConvertUnsafe's generated struct types do: see clearWrapperName.
+// Field access, assignment and JSON marshaling of the data itself are
+// unaffected.
+//
+// ConvertCyclic relies on the same internal type-descriptor layout
+// ConvertUnsafe does and is gated by the same unsafeStructRewriteSupported
+// check.
+//
+// Like Convert, the generated root type is cached by (source type, maker):
+// the whole connected component of a type graph is resolved and cached
+// atomically under one cache entry, so concurrent or repeated calls for the
+// same (type, maker) pair always see the fully-built result, never a
+// partially-patched one, and reuse the same reflect.Type rather than
+// building a distinct analogue every time.
+func ConvertCyclic(p interface{}, maker TagMaker) interface{} {
+	if !unsafeStructRewriteSupported {
+		panic("tags.ConvertCyclic: unverified on " + runtime.Version() + ", the internal type layout this relies on has not been checked for this Go version")
+	}
+	strPtrVal := reflect.ValueOf(p)
+	if strPtrVal.Kind() != reflect.Ptr {
+		panic(fmt.Sprintf("tags.ConvertCyclic: p should be a pointer, got %s", strPtrVal.Kind()))
+	}
+	newType := getCyclicType(strPtrVal.Type().Elem(), maker)
+	newPtrVal := reflect.NewAt(newType, unsafe.Pointer(strPtrVal.Pointer()))
+	return newPtrVal.Interface()
+}
+
+var cyclicCache = newTypeCache()
+
+// getCyclicType resolves rootType's whole connected component in one
+// typeCache entry, the same way getType/getUnsafeStructType cache a single
+// type: concurrent or repeated calls for the same (rootType, maker) pair
+// share one cyclicBuilder's result instead of each racing to build (and
+// separately patch) their own copy of the component.
+func getCyclicType(rootType reflect.Type, maker TagMaker) reflect.Type {
+	key := cacheKey{rootType, maker, ""}
+	return cyclicCache.get(key, func() reflect.Type {
+		b := &cyclicBuilder{maker: maker, nodes: make(map[reflect.Type]*cyclicNode)}
+		return b.resolve(rootType)
+	})
+}
+
+// cyclicNode tracks the progress of building one struct type's analogue
+// during a single ConvertCyclic call.
+type cyclicNode struct {
+	final       reflect.Type               // set once the struct's own fields are resolved
+	placeholder reflect.Type               // lazily-built distinct stand-in, used only as an Elem target
+	pending     []func(final reflect.Type) // patches to run once final is known
+}
+
+type cyclicBuilder struct {
+	maker TagMaker
+	nodes map[reflect.Type]*cyclicNode
+}
+
+func (b *cyclicBuilder) resolve(t reflect.Type) reflect.Type {
+	switch t.Kind() {
+	case reflect.Struct:
+		return b.resolveStruct(t)
+	case reflect.Ptr:
+		return b.resolveWrapped(t.Elem(), reflect.PtrTo, patchPtrElem)
+	case reflect.Slice:
+		return b.resolveWrapped(t.Elem(), reflect.SliceOf, patchSliceElem)
+	case reflect.Map:
+		return b.resolveMap(t)
+	case reflect.Array:
+		return reflect.ArrayOf(t.Len(), b.resolve(t.Elem()))
+	case
+		reflect.Chan,
+		reflect.Func,
+		reflect.UnsafePointer,
+		reflect.Interface:
+		panic("tags.Map: Unsupported type: " + t.Kind().String())
+	default:
+		return t
+	}
+}
+
+// resolveWrapped resolves the element of a pointer or slice type. If elemType
+// is a struct that is still being built (a cycle), it returns a distinct
+// placeholder wrapped the same way and registers patch to run once that
+// struct's real type is known; otherwise it resolves elemType normally.
+func (b *cyclicBuilder) resolveWrapped(elemType reflect.Type, wrap func(reflect.Type) reflect.Type, patch func(wrapper, final reflect.Type)) reflect.Type {
+	if node, ok := b.pendingNodeFor(elemType); ok {
+		wrapper := wrap(b.placeholderFor(node))
+		clearWrapperName(rtypePointer(wrapper))
+		node.pending = append(node.pending, func(final reflect.Type) { patch(wrapper, final) })
+		return wrapper
+	}
+	return wrap(b.resolve(elemType))
+}
+
+// resolveMap resolves a map type's key and element. Unlike pointers and
+// slices, a map's internal/abi.MapType bakes in its bucket layout, hasher
+// function, and key/element size - all computed from the key/element type's
+// full, final layout - so there is no equivalent of patchPtrElem/
+// patchSliceElem that could fix those up in place once a placeholder's real
+// type becomes known. A struct used by value as a map key or element that is
+// itself still being built (i.e. the cycle reaches this map through that
+// struct) can therefore not be supported: resolveMap panics instead of
+// producing a map type whose bucket metadata doesn't match its own key/elem
+// types, which would corrupt map storage once the map grows past its first
+// bucket. A struct reached through a pointer or slice typed map key/element
+// (e.g. map[string]*Node) has no such problem, since pendingNodeFor only
+// matches struct kinds and never matches the pointer/slice itself.
+func (b *cyclicBuilder) resolveMap(t reflect.Type) reflect.Type {
+	if _, pending := b.pendingNodeFor(t.Key()); pending {
+		panic(fmt.Sprintf("tags.ConvertCyclic: %s is used by value as the key of %s in a cycle; wrap it in a pointer (e.g. map[%s]*%s) instead", t.Key(), t, t.Key(), t.Key()))
+	}
+	if _, pending := b.pendingNodeFor(t.Elem()); pending {
+		panic(fmt.Sprintf("tags.ConvertCyclic: %s is used by value as the element of %s in a cycle; wrap it in a pointer (e.g. map[...]*%s) instead", t.Elem(), t, t.Elem()))
+	}
+	return reflect.MapOf(b.resolve(t.Key()), b.resolve(t.Elem()))
+}
+
+// pendingNodeFor reports the in-progress node for t, if t is a struct
+// ConvertCyclic is still in the middle of building.
+func (b *cyclicBuilder) pendingNodeFor(t reflect.Type) (*cyclicNode, bool) {
+	if t.Kind() != reflect.Struct {
+		return nil, false
+	}
+	node, ok := b.nodes[t]
+	if !ok || node.final != nil {
+		return nil, false
+	}
+	return node, true
+}
+
+func (b *cyclicBuilder) resolveStruct(t reflect.Type) reflect.Type {
+	if node, ok := b.nodes[t]; ok {
+		if node.final != nil {
+			return node.final
+		}
+		return b.placeholderFor(node)
+	}
+	node := &cyclicNode{}
+	b.nodes[t] = node
+
+	final := t
+	if t.NumField() > 0 {
+		changed := false
+		hasPrivate := false
+		fields := make([]reflect.StructField, 0, t.NumField())
+		for i := 0; i < t.NumField(); i++ {
+			strField := t.Field(i)
+			if isExported(strField.Name) {
+				oldType := strField.Type
+				newType := b.resolve(oldType)
+				strField.Type = newType
+				if oldType != newType {
+					changed = true
+				}
+				oldTag := strField.Tag
+				newTag := b.maker.MakeTag(t, i)
+				strField.Tag = newTag
+				if oldTag != newTag {
+					changed = true
+				}
+			} else {
+				hasPrivate = true
+				if !structTypeConstructorBugWasFixed {
+					strField.PkgPath = ""
+					strField.Name = ""
+				}
+			}
+			fields = append(fields, strField)
+		}
+		if changed {
+			if hasPrivate {
+				panic(fmt.Sprintf("unable to change tags for type %s, because it contains unexported fields", t))
+			}
+			final = reflect.StructOf(fields)
+			compareStructTypes(t, final)
+		}
+	}
+
+	node.final = final
+	pending := node.pending
+	node.pending = nil
+	for _, patch := range pending {
+		patch(final)
+	}
+	return final
+}
+
+var cyclicPlaceholderSeq int64
+
+// placeholderFor lazily builds node's distinct stand-in type: a single-field
+// struct whose field tag embeds a process-wide unique id, so that wrapping
+// it with reflect.PtrTo/SliceOf always allocates a fresh wrapper type
+// rather than reusing one reflect has already cached for an identical field
+// list.
+func (b *cyclicBuilder) placeholderFor(node *cyclicNode) reflect.Type {
+	if node.placeholder == nil {
+		id := atomic.AddInt64(&cyclicPlaceholderSeq, 1)
+		node.placeholder = reflect.StructOf([]reflect.StructField{
+			{
+				Name: "Placeholder",
+				Type: reflect.TypeOf(uintptr(0)),
+				Tag:  reflect.StructTag(fmt.Sprintf(`retag:"cyclicPlaceholder%d"`, id)),
+			},
+		})
+	}
+	return node.placeholder
+}
+
+// The mirror types below extend unsafeAbiType (declared in unsafe.go) to
+// match internal/abi's PtrType/SliceType layout, just enough to reach the
+// Elem field patchPtrElem/patchSliceElem rewrite in place. There is no
+// unsafeMapType/patchMapKey/patchMapElem equivalent - see resolveMap.
+type unsafePtrType struct {
+	unsafeAbiType
+	elem unsafe.Pointer
+}
+
+type unsafeSliceType struct {
+	unsafeAbiType
+	elem unsafe.Pointer
+}
+
+func patchPtrElem(wrapper, final reflect.Type) {
+	(*unsafePtrType)(rtypePointer(wrapper)).elem = rtypePointer(final)
+}
+
+func patchSliceElem(wrapper, final reflect.Type) {
+	(*unsafeSliceType)(rtypePointer(wrapper)).elem = rtypePointer(final)
+}
@@ -0,0 +1,104 @@
+package retag_test
+
+import (
+	"reflect"
+	"testing"
+
+	retag "github.com/slowaner/go-retag"
+)
+
+type Node struct {
+	Value int   `json:"value"`
+	Next  *Node `json:"next"`
+}
+
+// TestConvertCyclicSelfReferential checks that ConvertCyclic retags every
+// field of a self-referential type and that walking the result through its
+// Next pointer lands back on the original value, unchanged.
+func TestConvertCyclicSelfReferential(t *testing.T) {
+	n1 := &Node{Value: 1}
+	n2 := &Node{Value: 2}
+	n1.Next = n2
+	n2.Next = n1
+
+	result := retag.ConvertCyclic(n1, jsonSuffixMaker{})
+	rv := reflect.ValueOf(result).Elem()
+
+	if got, want := rv.Type().Field(0).Tag, reflect.StructTag(`json:"Value_retagged"`); got != want {
+		t.Errorf("Value tag = %q, want %q", got, want)
+	}
+
+	next := rv.Field(1).Elem()
+	if got, want := next.Field(0).Interface(), 2; got != want {
+		t.Errorf("Next.Value = %v, want %v", got, want)
+	}
+	nextNext := next.Field(1).Elem()
+	if got, want := nextNext.Addr().Interface(), result; got != want {
+		t.Error("Next.Next should point back to the original converted value")
+	}
+}
+
+// TestConvertCyclicSameTypeForRepeatedCalls checks that ConvertCyclic caches
+// the generated type by (source type, maker): two unrelated values of the
+// same cyclic type must convert to the exact same reflect.Type, the same
+// identity guarantee Convert's cache gives non-cyclic types.
+func TestConvertCyclicSameTypeForRepeatedCalls(t *testing.T) {
+	a := &Node{Value: 1}
+	a.Next = a
+	b := &Node{Value: 2}
+	b.Next = b
+
+	ra := retag.ConvertCyclic(a, jsonSuffixMaker{})
+	rb := retag.ConvertCyclic(b, jsonSuffixMaker{})
+
+	if reflect.TypeOf(ra) != reflect.TypeOf(rb) {
+		t.Error("ConvertCyclic generated distinct types for the same (type, maker) pair")
+	}
+}
+
+type ValueMapNode struct {
+	Value    int64
+	Children map[string]ValueMapNode
+}
+
+// TestConvertCyclicPanicsOnMapByValueCycle checks that ConvertCyclic refuses
+// to build a map whose key or element type is a struct reached by value
+// through a cycle: a map's bucket layout, hasher and key/element size are
+// baked into its type descriptor from the placeholder it would otherwise be
+// built with, and can't be fixed up once the real struct type is known.
+func TestConvertCyclicPanicsOnMapByValueCycle(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Fatal("expected ConvertCyclic to panic on a map-by-value cycle")
+		}
+	}()
+	n := &ValueMapNode{Value: 1, Children: map[string]ValueMapNode{}}
+	retag.ConvertCyclic(n, jsonSuffixMaker{})
+}
+
+type PtrMapNode struct {
+	Value    int64                  `json:"value"`
+	Children map[string]*PtrMapNode `json:"children"`
+}
+
+// TestConvertCyclicMapByPointerCycle checks that a map keyed or valued by a
+// pointer to the cyclic struct (rather than the struct itself) is still
+// supported, since pendingNodeFor never matches the pointer type itself.
+func TestConvertCyclicMapByPointerCycle(t *testing.T) {
+	root := &PtrMapNode{Value: 1, Children: map[string]*PtrMapNode{}}
+	child := &PtrMapNode{Value: 2, Children: map[string]*PtrMapNode{}}
+	root.Children["c"] = child
+	child.Children["root"] = root
+
+	result := retag.ConvertCyclic(root, jsonSuffixMaker{})
+	rv := reflect.ValueOf(result).Elem()
+
+	childVal := rv.FieldByName("Children").MapIndex(reflect.ValueOf("c")).Elem()
+	if got, want := childVal.FieldByName("Value").Interface(), int64(2); got != want {
+		t.Errorf("child value = %v, want %v", got, want)
+	}
+	backToRoot := childVal.FieldByName("Children").MapIndex(reflect.ValueOf("root")).Elem()
+	if backToRoot.Addr().Interface() != result {
+		t.Error("map edge back to root should point at the same converted value")
+	}
+}
@@ -20,6 +20,22 @@ type TagMaker interface {
 	MakeTag(structureType reflect.Type, fieldIndex int) reflect.StructTag
 }
 
+// TagMakerWithPath is an extended TagMaker that also sees where in the type
+// tree the field it is tagging lives. makeStructType prefers this interface
+// over TagMaker whenever a maker implements it.
+// A type that implements TagMakerWithPath should be comparable.
+type TagMakerWithPath interface {
+	// MakeTag makes a tag for the field at fieldIndex in the structure type
+	// that path leads to: path holds every parent reflect.StructField from
+	// the root value passed to Convert down to, and including, the field
+	// through which that structure is reached in its parent. path is empty
+	// for fields of the root structure itself.
+	// Result should depends on constant parameters of creation of the
+	// TagMakerWithPath and parameters passed to the MakeTag. The MakeTag
+	// should not produce side effects (like a pure function).
+	MakeTag(path []reflect.StructField, fieldIndex int) reflect.StructTag
+}
+
 // Convert converts the given interface p, to a runtime-generated type.
 // The type is generated on base of source type by the next rules:
 //   - Analogous type with custom tags is generated for structures.
@@ -28,9 +44,16 @@ type TagMaker interface {
 //     which should be replaced with its own analogue or if it is structure.
 //	 - A type of private fields of structures is not modified.
 //
-// Convert panics if argument p has a type different from a pointer to structure.
+// Convert panics if argument p is not a pointer. The pointed-to type is not required
+// to be a structure: a pointer to a slice, array, map or another pointer is also accepted,
+// and the analogue is built for whatever structures are reachable through it
+// (e.g. *[]MyStruct, *map[string]MyStruct or *[3]MyStruct).
 // The maker's underlying type should be comparable. In different case panic occurs.
 //
+// maker should implement TagMaker or TagMakerWithPath; Convert panics otherwise.
+// The two are mutually exclusive interfaces (both declare a MakeTag method, with
+// different signatures), so maker is accepted as interface{} rather than TagMaker.
+//
 // Convert panics if the maker attempts to change a field tag of a structure with unexported fields
 // because reflect package doesn't support creation of a structure type with private fields.
 //
@@ -48,55 +71,140 @@ type TagMaker interface {
 //
 // BUG(yar): Convert panics on structure with a final zero-size field in go1.7.
 // It is fixed in go1.8 (see github.com/golang/go/issues/18016).
-func Convert(p interface{}, maker TagMaker) interface{} {
+func Convert(p interface{}, maker interface{}) interface{} {
 	strPtrVal := reflect.ValueOf(p)
-	// TODO(yar): check type (pointer to the structure)
-	newType := getType(strPtrVal.Type().Elem(), maker)
+	if strPtrVal.Kind() != reflect.Ptr {
+		panic(fmt.Sprintf("tags.Convert: p should be a pointer, got %s", strPtrVal.Kind()))
+	}
+	switch maker.(type) {
+	case TagMaker, TagMakerWithPath:
+	default:
+		panic(fmt.Sprintf("tags.Convert: maker of type %T implements neither TagMaker nor TagMakerWithPath", maker))
+	}
+	newType := getType(strPtrVal.Type().Elem(), maker, nil)
 	newPtrVal := reflect.NewAt(newType, unsafe.Pointer(strPtrVal.Pointer()))
 	return newPtrVal.Interface()
 }
 
 type cacheKey struct {
 	reflect.Type
-	TagMaker
+	maker interface{}
+	path  string
+}
+
+// pathKeyFor returns the path component of a cacheKey for maker and path.
+// It is empty unless maker implements TagMakerWithPath: a plain TagMaker's
+// MakeTag result never depends on path, so the same (type, maker) pair
+// reached through two different paths should still hit one cache entry
+// instead of generating (and reflect.StructOf-ing) the same analogue twice.
+func pathKeyFor(maker interface{}, path []reflect.StructField) string {
+	if _, ok := maker.(TagMakerWithPath); !ok {
+		return ""
+	}
+	return pathKey(path)
+}
+
+// pathKey turns a field path into a string suitable for use in cacheKey, so
+// that the same (type, maker) pair reached through two different paths isn't
+// wrongly treated as the same cache entry when maker is path-aware.
+func pathKey(path []reflect.StructField) string {
+	if len(path) == 0 {
+		return ""
+	}
+	parts := make([]string, len(path))
+	for i, field := range path {
+		parts[i] = field.Type.String() + "." + field.Name
+	}
+	return strings.Join(parts, "/")
+}
+
+// typeEntry holds the result of generating the analogue for a single cacheKey.
+// The done channel is closed once either typ or err is populated, so goroutines
+// that find an in-flight entry can wait for the first goroutine to finish
+// instead of generating (and caching) their own, possibly distinct, reflect.Type.
+type typeEntry struct {
+	done chan struct{}
+	typ  reflect.Type
+	err  interface{} // recovered panic value, if makeType(-like) panicked
+}
+
+// typeCache is a single-flight cache of generated types keyed by cacheKey:
+// concurrent callers that ask for the same key while it's still being built
+// wait for the in-flight build instead of generating (and caching) their own,
+// possibly distinct, reflect.Type. getType and getUnsafeStructType each keep
+// their own typeCache, since a structure's analogue under Convert and under
+// ConvertUnsafe aren't interchangeable.
+type typeCache struct {
+	sync.Mutex
+	m map[cacheKey]*typeEntry
 }
 
-var cache = struct {
-	sync.RWMutex
-	m map[cacheKey]reflect.Type
-}{
-	m: make(map[cacheKey]reflect.Type),
+func newTypeCache() *typeCache {
+	return &typeCache{m: make(map[cacheKey]*typeEntry)}
 }
 
-func getType(structType reflect.Type, maker TagMaker) reflect.Type {
-	// TODO(yar): Improve syncronization for cases when one analogue
-	// is produced concurently by different goroutines in the same time
-	key := cacheKey{structType, maker}
-	cache.RLock()
-	t, ok := cache.m[key]
-	cache.RUnlock()
+// get returns the cached type for key, building it with build if this is the
+// first request for key. If build panics, the cache entry for key is removed
+// so later callers retry from scratch, and every goroutine already waiting on
+// this call (including this one) re-panics with the same value, instead of
+// some of them hanging forever on a typeEntry that will never close its
+// done channel.
+func (c *typeCache) get(key cacheKey, build func() reflect.Type) reflect.Type {
+	c.Lock()
+	entry, ok := c.m[key]
+	if !ok {
+		entry = &typeEntry{done: make(chan struct{})}
+		c.m[key] = entry
+	}
+	c.Unlock()
 	if !ok {
-		t = makeType(structType, maker)
-		cache.Lock()
-		cache.m[key] = t
-		cache.Unlock()
+		c.buildEntry(key, entry, build)
+	} else {
+		<-entry.done
+	}
+	if entry.err != nil {
+		panic(entry.err)
 	}
-	return t
+	return entry.typ
+}
+
+func (c *typeCache) buildEntry(key cacheKey, entry *typeEntry, build func() reflect.Type) {
+	defer func() {
+		if r := recover(); r != nil {
+			c.Lock()
+			if c.m[key] == entry {
+				delete(c.m, key)
+			}
+			c.Unlock()
+			entry.err = r
+			close(entry.done)
+			panic(r)
+		}
+	}()
+	entry.typ = build()
+	close(entry.done)
+}
+
+var cache = newTypeCache()
+
+func getType(structType reflect.Type, maker interface{}, path []reflect.StructField) reflect.Type {
+	key := cacheKey{structType, maker, pathKeyFor(maker, path)}
+	return cache.get(key, func() reflect.Type { return makeType(structType, maker, path) })
 }
 
 // TODO(yar): Optimize cases when type is not modified.
-func makeType(t reflect.Type, maker TagMaker) reflect.Type {
+func makeType(t reflect.Type, maker interface{}, path []reflect.StructField) reflect.Type {
 	switch t.Kind() {
 	case reflect.Struct:
-		return makeStructType(t, maker)
+		return makeStructType(t, maker, path)
 	case reflect.Ptr:
-		return reflect.PtrTo(getType(t.Elem(), maker))
+		return reflect.PtrTo(getType(t.Elem(), maker, path))
 	case reflect.Array:
-		return reflect.ArrayOf(t.Len(), getType(t.Elem(), maker))
+		return reflect.ArrayOf(t.Len(), getType(t.Elem(), maker, path))
 	case reflect.Slice:
-		return reflect.SliceOf(getType(t.Elem(), maker))
+		return reflect.SliceOf(getType(t.Elem(), maker, path))
 	case reflect.Map:
-		return reflect.MapOf(getType(t.Key(), maker), getType(t.Elem(), maker))
+		return reflect.MapOf(getType(t.Key(), maker, path), getType(t.Elem(), maker, path))
 	case
 		reflect.Chan,
 		reflect.Func,
@@ -109,7 +217,7 @@ func makeType(t reflect.Type, maker TagMaker) reflect.Type {
 	}
 }
 
-func makeStructType(structType reflect.Type, maker TagMaker) reflect.Type {
+func makeStructType(structType reflect.Type, maker interface{}, path []reflect.StructField) reflect.Type {
 	if structType.NumField() == 0 {
 		return structType
 	}
@@ -119,14 +227,18 @@ func makeStructType(structType reflect.Type, maker TagMaker) reflect.Type {
 	for i := 0; i < structType.NumField(); i++ {
 		strField := structType.Field(i)
 		if isExported(strField.Name) {
+			childPath := make([]reflect.StructField, len(path)+1)
+			copy(childPath, path)
+			childPath[len(path)] = strField
+
 			oldType := strField.Type
-			newType := getType(oldType, maker)
+			newType := getType(oldType, maker, childPath)
 			strField.Type = newType
 			if oldType != newType {
 				changed = true
 			}
 			oldTag := strField.Tag
-			newTag := maker.MakeTag(structType, i)
+			newTag := makeFieldTag(maker, path, structType, i)
 			strField.Tag = newTag
 			if oldTag != newTag {
 				changed = true
@@ -152,6 +264,19 @@ func makeStructType(structType reflect.Type, maker TagMaker) reflect.Type {
 	return newType
 }
 
+// makeFieldTag generates the tag for the fieldIndex field of structType,
+// preferring TagMakerWithPath over the plain TagMaker interface.
+func makeFieldTag(maker interface{}, path []reflect.StructField, structType reflect.Type, fieldIndex int) reflect.StructTag {
+	switch m := maker.(type) {
+	case TagMakerWithPath:
+		return m.MakeTag(path, fieldIndex)
+	case TagMaker:
+		return m.MakeTag(structType, fieldIndex)
+	default:
+		panic(fmt.Sprintf("tags.Convert: maker of type %T implements neither TagMaker nor TagMakerWithPath", maker))
+	}
+}
+
 func isExported(name string) bool {
 	b := name[0]
 	return !('a' <= b && b <= 'z') && b != '_'
@@ -0,0 +1,132 @@
+package retag_test
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	retag "github.com/slowaner/go-retag"
+)
+
+type suffixMaker struct{ suffix string }
+
+func (m suffixMaker) MakeTag(t reflect.Type, i int) reflect.StructTag {
+	return reflect.StructTag(`json:"` + t.Field(i).Name + m.suffix + `"`)
+}
+
+type structWithPrivateField struct {
+	Pub  string
+	priv int
+}
+
+// mustConvertPanic runs Convert and fails the test if it does not panic.
+func mustConvertPanic(t *testing.T, p interface{}, maker interface{}) {
+	t.Helper()
+	defer func() {
+		if recover() == nil {
+			t.Fatal("expected Convert to panic")
+		}
+	}()
+	retag.Convert(p, maker)
+}
+
+// TestConvertPanicDoesNotPoisonCache makes sure that a maker panicking on a
+// structure with unexported fields doesn't leave later calls for the same
+// (type, maker) pair hanging forever: the first call panics, and a second,
+// concurrent call for the same key must panic too, promptly, rather than
+// block on a cache entry whose done channel never closes.
+func TestConvertPanicDoesNotPoisonCache(t *testing.T) {
+	maker := suffixMaker{suffix: "_x"}
+
+	mustConvertPanic(t, &structWithPrivateField{}, maker)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		mustConvertPanic(t, &structWithPrivateField{}, maker)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("second call on the same (type, maker) key hung after the first call panicked")
+	}
+}
+
+type Address struct {
+	City string
+}
+
+type User struct {
+	Home Address
+	Work Address
+}
+
+type countingMaker struct{ calls *int }
+
+func (m countingMaker) MakeTag(t reflect.Type, i int) reflect.StructTag {
+	*m.calls++
+	return reflect.StructTag(`json:"x"`)
+}
+
+type countingPathMaker struct{ calls *int }
+
+func (m countingPathMaker) MakeTag(path []reflect.StructField, i int) reflect.StructTag {
+	*m.calls++
+	return reflect.StructTag(`json:"x"`)
+}
+
+// TestPlainTagMakerSharesCacheAcrossPaths checks that a plain TagMaker (whose
+// result can't depend on path) only generates the Address analogue once,
+// even though Address is reached through two different field paths
+// (User.Home and User.Work): makeStructType/getType must not key the cache
+// by path unless maker implements TagMakerWithPath.
+func TestPlainTagMakerSharesCacheAcrossPaths(t *testing.T) {
+	var calls int
+	retag.Convert(&User{}, countingMaker{calls: &calls})
+	// 2 calls for User's own fields (Home, Work) + 1 call for Address.City,
+	// generated once and reused for both paths.
+	if calls != 3 {
+		t.Errorf("MakeTag called %d times, want 3 (Address should be generated once, not once per path)", calls)
+	}
+}
+
+// TestPathAwareTagMakerStillSeparatesPaths checks that a TagMakerWithPath
+// maker, whose result can legitimately depend on path, still gets a distinct
+// cache entry (and so a separate MakeTag call) per path.
+func TestPathAwareTagMakerStillSeparatesPaths(t *testing.T) {
+	var calls int
+	retag.Convert(&User{}, countingPathMaker{calls: &calls})
+	// 2 calls for User's own fields + 1 call for Address.City per path (x2).
+	if calls != 4 {
+		t.Errorf("MakeTag called %d times, want 4 (Address should be generated once per distinct path)", calls)
+	}
+}
+
+// TestConvertConcurrentSameKey checks that concurrent Convert calls for the
+// exact same (type, maker) key all observe a finished result and none of
+// them hangs.
+func TestConvertConcurrentSameKey(t *testing.T) {
+	maker := suffixMaker{suffix: "_y"}
+	const n = 16
+	results := make(chan reflect.Type, n)
+	for i := 0; i < n; i++ {
+		go func() {
+			u := retag.Convert(&User{}, maker)
+			results <- reflect.TypeOf(u).Elem()
+		}()
+	}
+	var first reflect.Type
+	for i := 0; i < n; i++ {
+		select {
+		case typ := <-results:
+			if first == nil {
+				first = typ
+			} else if typ != first {
+				t.Fatalf("got different generated types for the same (type, maker) key: %v vs %v", first, typ)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatal("a concurrent Convert call hung")
+		}
+	}
+}
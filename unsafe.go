@@ -0,0 +1,250 @@
+package retag
+
+import (
+	"encoding/binary"
+	"fmt"
+	"reflect"
+	"runtime"
+	"strconv"
+	"strings"
+	"unsafe"
+)
+
+// ConvertUnsafe is like Convert, but additionally accepts a pointer to a
+// structure that has unexported fields, which makeStructType otherwise
+// rejects because reflect.StructOf refuses to build a type with unexported
+// fields.
+//
+// Instead of going through reflect.StructOf, ConvertUnsafe makes a
+// byte-for-byte copy of the source type's runtime type descriptor and of its
+// field array, and only replaces the name/tag data of the exported fields
+// the maker wants to retag. Every field keeps its original offset, and
+// unexported fields are left completely untouched, so the generated type has
+// the same size, alignment and in-memory layout as the source: values can be
+// reinterpreted between the two exactly like Convert does for the safe path.
+//
+// ConvertUnsafe only descends into fields that are themselves structures;
+// a slice, map, array or pointer field keeps its original field type (only
+// its own tag, if the field is exported, is regenerated), since retagging
+// those would need reflect.StructOf-like type generation deeper in the type,
+// which is exactly what this entry point exists to avoid.
+//
+// Methods of the source type are not carried over to the generated type, the
+// same way Convert's reflect.StructOf-generated types never have them.
+//
+// ConvertUnsafe relies on the memory layout the reflect/internal-abi package
+// has used since go1.17 for rtype/structType/structField/name. It refuses to
+// run (see unsafeStructRewriteSupported) on a toolchain it hasn't been
+// exercised against; treat it as tied to the Go version it ships with.
+//
+// The generated type's Name() and String() are both empty: the descriptor is
+// never registered with the runtime's module data, so nothing safe to return
+// from those accessors survives the copy. Field names, tags and values are
+// unaffected; only the type's own self-reported name is lost.
+//
+// ConvertUnsafe panics if p is not a pointer to a structure, or if
+// unsafeStructRewriteSupported is false.
+func ConvertUnsafe(p interface{}, maker TagMaker) interface{} {
+	if !unsafeStructRewriteSupported {
+		panic("tags.ConvertUnsafe: unverified on " + runtime.Version() + ", the internal struct layout this relies on has not been checked for this Go version")
+	}
+	strPtrVal := reflect.ValueOf(p)
+	if strPtrVal.Kind() != reflect.Ptr || strPtrVal.Elem().Kind() != reflect.Struct {
+		panic(fmt.Sprintf("tags.ConvertUnsafe: p should be a pointer to a structure, got %s", strPtrVal.Type()))
+	}
+	newType := getUnsafeStructType(strPtrVal.Type().Elem(), maker)
+	newPtrVal := reflect.NewAt(newType, unsafe.Pointer(strPtrVal.Pointer()))
+	return newPtrVal.Interface()
+}
+
+var unsafeCache = newTypeCache()
+
+func getUnsafeStructType(structType reflect.Type, maker TagMaker) reflect.Type {
+	key := cacheKey{structType, maker, ""}
+	return unsafeCache.get(key, func() reflect.Type { return makeStructTypeUnsafe(structType, maker) })
+}
+
+func makeStructTypeUnsafe(structType reflect.Type, maker TagMaker) reflect.Type {
+	srcHeader := (*unsafeStructType)(rtypePointer(structType))
+	fields := make([]unsafeStructField, len(srcHeader.fields))
+	copy(fields, srcHeader.fields)
+
+	changed := false
+	for i := range fields {
+		strField := structType.Field(i)
+		if !isExported(strField.Name) {
+			continue
+		}
+		if strField.Type.Kind() == reflect.Struct {
+			newFieldType := getUnsafeStructType(strField.Type, maker)
+			if newFieldType != strField.Type {
+				fields[i].typ = rtypePointer(newFieldType)
+				changed = true
+			}
+		}
+		newTag := maker.MakeTag(structType, i)
+		if newTag != strField.Tag {
+			fields[i].name = newUnsafeName(strField.Name, string(newTag), true, strField.Anonymous)
+			changed = true
+		}
+	}
+	if !changed {
+		return structType
+	}
+
+	newHeader := *srcHeader
+	newHeader.fields = fields
+	clearWrapperName(unsafe.Pointer(&newHeader))
+
+	newType := toReflectType(unsafe.Pointer(&newHeader), structType)
+	compareStructTypes(structType, newType)
+	return newType
+}
+
+// The types below mirror the layout internal/abi has given
+// Type/StructType/StructField/Name since go1.17. They exist only so that
+// makeStructTypeUnsafe can reinterpret a real reflect.Type's backing memory
+// and copy it field by field; nothing here is standalone-meaningful.
+type unsafeName struct {
+	bytes *byte
+}
+
+type unsafeAbiType struct {
+	size       uintptr
+	ptrBytes   uintptr
+	hash       uint32
+	tflag      uint8
+	align      uint8
+	fieldAlign uint8
+	kind       uint8
+	equal      func(unsafe.Pointer, unsafe.Pointer) bool
+	gcdata     *byte
+	str        int32
+	ptrToThis  int32
+}
+
+type unsafeStructField struct {
+	name   unsafeName
+	typ    unsafe.Pointer
+	offset uintptr
+}
+
+type unsafeStructType struct {
+	unsafeAbiType
+	pkgPath unsafeName
+	fields  []unsafeStructField
+}
+
+const (
+	tflagUncommon  = 1 << 0
+	tflagExtraStar = 1 << 1
+	tflagNamed     = 1 << 2
+)
+
+// clearWrapperName blanks out a freshly built or patched type descriptor's
+// cached name info. Left alone it would keep describing whatever type it was
+// copied or built from; since that name is resolved through an offset
+// relative to the runtime's module data, leaving it in place risks a fatal
+// "offset out of range" the first time something calls String() or Name() on
+// the result. An empty name is a harmless, if not very informative,
+// stand-in. tflagUncommon is cleared too, because its method table would
+// live right after the source descriptor in memory, not after ours.
+func clearWrapperName(ptr unsafe.Pointer) {
+	t := (*unsafeAbiType)(ptr)
+	t.tflag &^= (tflagUncommon | tflagExtraStar | tflagNamed)
+	t.str = 0
+	t.ptrToThis = 0
+}
+
+// emptyInterface mirrors the runtime's representation of a non-empty or
+// empty interface value: a pointer to the concrete type's descriptor and a
+// pointer to (or, for pointer-shaped types, holding directly) the data.
+type emptyInterface struct {
+	typ  unsafe.Pointer
+	word unsafe.Pointer
+}
+
+// rtypePointer returns the address of t's own runtime type descriptor.
+// reflect.Type's sole implementation is a pointer type, so it is stored
+// directly in the interface's data word without further indirection.
+func rtypePointer(t reflect.Type) unsafe.Pointer {
+	return (*emptyInterface)(unsafe.Pointer(&t)).word
+}
+
+// toReflectType builds a reflect.Type whose data word is ptr, borrowing the
+// interface's type word from sample (any existing reflect.Type value, since
+// that word only ever identifies "this interface holds a *rtype").
+func toReflectType(ptr unsafe.Pointer, sample reflect.Type) reflect.Type {
+	face := emptyInterface{typ: (*emptyInterface)(unsafe.Pointer(&sample)).typ, word: ptr}
+	return *(*reflect.Type)(unsafe.Pointer(&face))
+}
+
+// newUnsafeName builds a fresh internal/abi.Name-encoded blob for a field
+// called name, carrying tag, and flagged exported/embedded as given. See
+// internal/abi.Name's doc comment for the byte format this produces.
+func newUnsafeName(name, tag string, exported, embedded bool) unsafeName {
+	var bits byte
+	if exported {
+		bits |= 1 << 0
+	}
+	if len(tag) > 0 {
+		bits |= 1 << 1
+	}
+	if embedded {
+		bits |= 1 << 3
+	}
+	var nameLenBuf [binary.MaxVarintLen64]byte
+	nameLenLen := putUvarint(nameLenBuf[:], len(name))
+	buf := make([]byte, 0, 1+nameLenLen+len(name)+binary.MaxVarintLen64+len(tag))
+	buf = append(buf, bits)
+	buf = append(buf, nameLenBuf[:nameLenLen]...)
+	buf = append(buf, name...)
+	if len(tag) > 0 {
+		var tagLenBuf [binary.MaxVarintLen64]byte
+		tagLenLen := putUvarint(tagLenBuf[:], len(tag))
+		buf = append(buf, tagLenBuf[:tagLenLen]...)
+		buf = append(buf, tag...)
+	}
+	return unsafeName{bytes: &buf[0]}
+}
+
+// putUvarint writes n in the same varint form internal/abi.Name uses
+// (identical to encoding/binary.PutUvarint for a non-negative n).
+func putUvarint(buf []byte, n int) int {
+	i := 0
+	for n >= 0x80 {
+		buf[i] = byte(n) | 0x80
+		n >>= 7
+		i++
+	}
+	buf[i] = byte(n)
+	return i + 1
+}
+
+var unsafeStructRewriteSupported bool
+
+func init() {
+	major, minor, ok := parseGoVersion(runtime.Version())
+	// The mirror types above have only been checked against the internal/abi
+	// layout go1.17 through go1.21 have shipped with; a later major/minor
+	// bump may change that layout without warning, so it isn't trusted
+	// without being re-verified first.
+	unsafeStructRewriteSupported = ok && major == 1 && minor >= 17 && minor <= 21
+}
+
+func parseGoVersion(version string) (major, minor int, ok bool) {
+	version = strings.TrimPrefix(version, "go")
+	parts := strings.SplitN(version, ".", 3)
+	if len(parts) < 2 {
+		return 0, 0, false
+	}
+	major, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return 0, 0, false
+	}
+	minor, err = strconv.Atoi(strings.TrimRightFunc(parts[1], func(r rune) bool { return r < '0' || r > '9' }))
+	if err != nil {
+		return 0, 0, false
+	}
+	return major, minor, true
+}
@@ -0,0 +1,70 @@
+package retag_test
+
+import (
+	"reflect"
+	"testing"
+
+	retag "github.com/slowaner/go-retag"
+)
+
+type privateFieldStruct struct {
+	Pub   string `json:"pub"`
+	priv  int
+	Other bool `json:"other"`
+}
+
+type jsonSuffixMaker struct{}
+
+func (jsonSuffixMaker) MakeTag(t reflect.Type, i int) reflect.StructTag {
+	return reflect.StructTag(`json:"` + t.Field(i).Name + `_retagged"`)
+}
+
+// TestConvertUnsafeRetagsExportedFieldsOnly checks that ConvertUnsafe, unlike
+// Convert, accepts a structure with an unexported field, regenerates tags
+// only for the exported fields, and preserves every field's value and the
+// unexported field's own data untouched.
+func TestConvertUnsafeRetagsExportedFieldsOnly(t *testing.T) {
+	src := &privateFieldStruct{Pub: "hello", priv: 42, Other: true}
+
+	result := retag.ConvertUnsafe(src, jsonSuffixMaker{})
+	rv := reflect.ValueOf(result).Elem()
+
+	if got, want := rv.Type().Field(0).Tag, reflect.StructTag(`json:"Pub_retagged"`); got != want {
+		t.Errorf("Pub tag = %q, want %q", got, want)
+	}
+	if got, want := rv.Type().Field(2).Tag, reflect.StructTag(`json:"Other_retagged"`); got != want {
+		t.Errorf("Other tag = %q, want %q", got, want)
+	}
+	if got, want := rv.FieldByName("Pub").String(), "hello"; got != want {
+		t.Errorf("Pub value = %q, want %q", got, want)
+	}
+	if got, want := rv.FieldByName("Other").Bool(), true; got != want {
+		t.Errorf("Other value = %v, want %v", got, want)
+	}
+	// src and result alias the same memory, so the conversion must have left
+	// the unexported field's data untouched.
+	if src.priv != 42 {
+		t.Errorf("unexported field was modified, got %d, want 42", src.priv)
+	}
+}
+
+// TestConvertUnsafeSameTypeForRepeatedCalls checks that ConvertUnsafe caches
+// its generated type by (source type, maker), the same way Convert does.
+func TestConvertUnsafeSameTypeForRepeatedCalls(t *testing.T) {
+	a := &privateFieldStruct{Pub: "a"}
+	b := &privateFieldStruct{Pub: "b"}
+
+	ra := retag.ConvertUnsafe(a, jsonSuffixMaker{})
+	rb := retag.ConvertUnsafe(b, jsonSuffixMaker{})
+
+	if reflect.TypeOf(ra) != reflect.TypeOf(rb) {
+		t.Error("ConvertUnsafe generated distinct types for the same (type, maker) pair")
+	}
+}
+
+// TestConvertPanicsOnPrivateFields checks that the safe Convert entry point
+// still refuses a structure with unexported fields, which is exactly the
+// case ConvertUnsafe exists to handle instead.
+func TestConvertPanicsOnPrivateFields(t *testing.T) {
+	mustConvertPanic(t, &privateFieldStruct{}, jsonSuffixMaker{})
+}